@@ -1,21 +1,42 @@
 package cache
 
-import "errors"
+import (
+	"errors"
+	"hash/fnv"
+	"time"
+)
 
 type ChacheStore interface {
 	Get(url string) (*ChacheData, error)
+	// Set stores the identity (uncompressed) representation of url's
+	// response, replacing any variants cached for a previous response.
 	Set(
 		url string,
 		/* or receive map[string]string */
 		contentType string,
 		content []byte,
+		// ttl overrides the store's configured default TTL for this
+		// entry; <=0 means use the default.
+		ttl time.Duration,
 	) error
+	// SetVariant adds or replaces one pre-compressed representation of
+	// an already-cached entry, keyed by its Content-Encoding. It is a
+	// no-op returning ErrNoSuchKey if the entry has since expired or
+	// been evicted.
+	SetVariant(url string, encoding string, content []byte) error
 }
 
+// IdentityEncoding is the Variants key ChacheData uses for the
+// uncompressed body, matching an absent Content-Encoding header.
+const IdentityEncoding = ""
+
+// ChacheData holds every representation cached for a URL, keyed by
+// Content-Encoding in Variants, so a request can be served the exact
+// encoding its client supports without re-fetching from origin.
 type ChacheData struct {
 	Eol         int64
 	ContentType string
-	Data        []byte
+	Variants    map[string][]byte
 }
 
 type EolData struct {
@@ -35,3 +56,12 @@ func SortEolData(a, b EolData) int {
 
 var ErrNoSuchKey error = errors.New("no such key")
 var ErrExpired error = errors.New("ttl expired")
+
+// HashKey is the FNV-128a hash every ChacheStore keys its entries on.
+func HashKey(key string) (string, error) {
+	hasher := fnv.New128a()
+	if _, err := hasher.Write([]byte(key)); err != nil {
+		return "", err
+	}
+	return string(hasher.Sum(nil)), nil
+}
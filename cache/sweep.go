@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// EolIndex is a goroutine-safe sorted index of key expirations. Backends
+// without native key TTL (MemoryStore, disk.DiskStore) use it to drive
+// periodic eviction; backends with native expiry (Redis, Memcached) let
+// the backend itself expire keys and have no use for it.
+type EolIndex struct {
+	mu     sync.Mutex
+	sorted []EolData
+}
+
+func NewEolIndex() *EolIndex {
+	return &EolIndex{}
+}
+
+func (idx *EolIndex) Add(key string, eol int64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.sorted = append(idx.sorted, EolData{Key: key, Eol: eol})
+	slices.SortFunc(idx.sorted, SortEolData)
+}
+
+// Sweep removes every entry whose Eol has passed now and returns their
+// keys, so the caller can delete them from its backing store.
+func (idx *EolIndex) Sweep(now int64) []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	var expired []string
+	for _, e := range idx.sorted {
+		if e.Eol >= now {
+			break
+		}
+		expired = append(expired, e.Key)
+	}
+
+	idx.sorted = slices.DeleteFunc(idx.sorted, func(e EolData) bool {
+		return e.Eol < now
+	})
+	return expired
+}
+
+// StartSweeping ticks every tick and calls onExpire for each key that
+// idx reports as expired. It runs for the lifetime of the process, so
+// it should only be started once per index.
+func StartSweeping(tick time.Duration, idx *EolIndex, onExpire func(key string)) {
+	go func() {
+		ticker := time.Tick(tick)
+		for t := range ticker {
+			for _, key := range idx.Sweep(t.Unix()) {
+				onExpire(key)
+			}
+		}
+	}()
+}
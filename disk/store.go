@@ -0,0 +1,411 @@
+// Package disk implements cache.ChacheStore on top of the local
+// filesystem, so that large static assets can be cached without holding
+// them all in RAM the way memory.MemoryStore does.
+package disk
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"ouchi/cache"
+	"ouchi/log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskStore persists each cached response as a pair of files under Dir:
+// a "<hash>.data" file holding the gob-encoded Variants map, and a
+// "<hash>.meta" file holding the Eol/ContentType alongside it. An
+// in-memory index of size + last-access time drives the MaxBytes quota
+// and a cache.EolIndex drives the TTL sweep, so normal lookups never
+// need to stat the filesystem.
+type DiskStore struct {
+	logger log.Logger
+
+	dir      string
+	maxBytes int64
+
+	ttlSec time.Duration
+
+	eolIndex *cache.EolIndex
+
+	mu        sync.Mutex
+	entries   map[string]*diskEntry
+	usedBytes int64
+
+	// variantMu guards the read-modify-write of a "<hash>.data" file
+	// done by SetVariant, since a gzip and a brotli variant for the
+	// same entry can be staged concurrently.
+	variantMu sync.Mutex
+}
+
+type diskEntry struct {
+	size       int64
+	lastAccess time.Time
+}
+
+type diskMeta struct {
+	Eol         int64  `json:"eol"`
+	ContentType string `json:"content_type"`
+}
+
+func NewDiskStore(
+	logger log.Logger,
+	dir string,
+	maxBytes int64,
+	tickSec time.Duration,
+	ttlSec time.Duration,
+) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	d := &DiskStore{
+		logger: logger,
+
+		dir:      dir,
+		maxBytes: maxBytes,
+
+		ttlSec: ttlSec,
+
+		eolIndex: cache.NewEolIndex(),
+		entries:  make(map[string]*diskEntry),
+	}
+
+	if err := d.rebuildIndex(); err != nil {
+		return nil, err
+	}
+
+	cache.StartSweeping(tickSec, d.eolIndex, d.expire)
+	return d, nil
+}
+
+func (d *DiskStore) expire(hash string) {
+	hashHex := hex.EncodeToString([]byte(hash))
+	d.removeFiles(hashHex)
+
+	d.mu.Lock()
+	if e, ok := d.entries[hash]; ok {
+		d.usedBytes -= e.size
+		delete(d.entries, hash)
+	}
+	d.mu.Unlock()
+
+	d.logger.Debugf("expired: %s", hashHex)
+}
+
+func (d *DiskStore) dataPath(hashHex string) string {
+	return filepath.Join(d.dir, hashHex+".data")
+}
+
+func (d *DiskStore) metaPath(hashHex string) string {
+	return filepath.Join(d.dir, hashHex+".meta")
+}
+
+// rebuildIndex walks Dir on startup so the cache survives restarts
+// without needing to be warmed again from origin.
+func (d *DiskStore) rebuildIndex() error {
+	files, err := os.ReadDir(d.dir)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, f := range files {
+		hashHex, ok := strings.CutSuffix(f.Name(), ".meta")
+		if !ok {
+			continue
+		}
+
+		m, err := d.readMeta(hashHex)
+		if err != nil {
+			d.logger.Error("skipping unreadable cache metadata", hashHex, err)
+			continue
+		}
+		if m.Eol < now {
+			d.removeFiles(hashHex)
+			continue
+		}
+
+		info, err := os.Stat(d.dataPath(hashHex))
+		if err != nil {
+			d.logger.Error("meta file without matching data file", hashHex, err)
+			d.removeFiles(hashHex)
+			continue
+		}
+
+		hash, err := hex.DecodeString(hashHex)
+		if err != nil {
+			d.logger.Error("malformed cache filename", hashHex, err)
+			continue
+		}
+
+		d.entries[string(hash)] = &diskEntry{
+			size:       info.Size(),
+			lastAccess: info.ModTime(),
+		}
+		d.usedBytes += info.Size()
+		d.eolIndex.Add(string(hash), m.Eol)
+	}
+
+	d.logger.Debugf("rebuilt disk cache index: %d entries, %d bytes", len(d.entries), d.usedBytes)
+	return nil
+}
+
+func (d *DiskStore) readMeta(hashHex string) (*diskMeta, error) {
+	b, err := os.ReadFile(d.metaPath(hashHex))
+	if err != nil {
+		return nil, err
+	}
+
+	m := &diskMeta{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (d *DiskStore) readVariants(hashHex string) (map[string][]byte, error) {
+	b, err := os.ReadFile(d.dataPath(hashHex))
+	if err != nil {
+		return nil, err
+	}
+
+	variants := map[string][]byte{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&variants); err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+func (d *DiskStore) writeVariants(hashHex string, variants map[string][]byte) (int64, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(variants); err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(d.dataPath(hashHex), buf.Bytes(), 0640); err != nil {
+		return 0, err
+	}
+	return int64(buf.Len()), nil
+}
+
+func (d *DiskStore) removeFiles(hashHex string) {
+	if err := os.Remove(d.dataPath(hashHex)); err != nil && !os.IsNotExist(err) {
+		d.logger.Error("failed to remove cache data file", hashHex, err)
+	}
+	if err := os.Remove(d.metaPath(hashHex)); err != nil && !os.IsNotExist(err) {
+		d.logger.Error("failed to remove cache meta file", hashHex, err)
+	}
+}
+
+func (d *DiskStore) Get(url string) (*cache.ChacheData, error) {
+	d.logger.Debugf("looking for %s", url)
+
+	hash, err := cache.HashKey(url)
+	if err != nil {
+		return nil, err
+	}
+	hashHex := hex.EncodeToString([]byte(hash))
+
+	d.mu.Lock()
+	e, ok := d.entries[hash]
+	d.mu.Unlock()
+	if !ok {
+		return nil, cache.ErrNoSuchKey
+	}
+
+	m, err := d.readMeta(hashHex)
+	if os.IsNotExist(err) {
+		// A concurrent sweep/eviction deleted the files between the
+		// entries check above and here; treat it as an ordinary miss.
+		return nil, cache.ErrNoSuchKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if m.Eol < now.Unix() {
+		return nil, cache.ErrExpired
+	}
+
+	variants, err := d.readVariants(hashHex)
+	if os.IsNotExist(err) {
+		return nil, cache.ErrNoSuchKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	e.lastAccess = now
+	d.mu.Unlock()
+
+	d.logger.Debugf("found cache: %s", url)
+	return &cache.ChacheData{
+		Eol:         m.Eol,
+		ContentType: m.ContentType,
+		Variants:    variants,
+	}, nil
+}
+
+func (d *DiskStore) Set(
+	url string,
+	contentType string,
+	content []byte,
+	ttl time.Duration,
+) error {
+	hash, err := cache.HashKey(url)
+	if err != nil {
+		return err
+	}
+	hashHex := hex.EncodeToString([]byte(hash))
+
+	if ttl <= 0 {
+		ttl = d.ttlSec
+	}
+	eol := time.Now().Add(ttl).Unix()
+	m := diskMeta{
+		Eol:         eol,
+		ContentType: contentType,
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	if err := d.makeRoom(int64(len(content))); err != nil {
+		return err
+	}
+
+	size, err := d.writeVariants(hashHex, map[string][]byte{cache.IdentityEncoding: content})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(d.metaPath(hashHex), b, 0640); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	d.mu.Lock()
+	if old, ok := d.entries[hash]; ok {
+		d.usedBytes -= old.size
+	}
+	d.entries[hash] = &diskEntry{
+		size:       size,
+		lastAccess: now,
+	}
+	d.usedBytes += size
+	d.mu.Unlock()
+	d.eolIndex.Add(hash, eol)
+
+	d.logger.Debugf(
+		"cached: [url] %s, [type] %s, [hash] %s",
+		url,
+		contentType,
+		hashHex,
+	)
+	return nil
+}
+
+// SetVariant adds or replaces one pre-compressed representation of an
+// already-cached entry. It leaves Eol and the quota/eviction bookkeeping
+// mostly untouched, only adjusting usedBytes for the size delta.
+func (d *DiskStore) SetVariant(url string, encoding string, content []byte) error {
+	hash, err := cache.HashKey(url)
+	if err != nil {
+		return err
+	}
+	hashHex := hex.EncodeToString([]byte(hash))
+
+	d.mu.Lock()
+	e, ok := d.entries[hash]
+	d.mu.Unlock()
+	if !ok {
+		return cache.ErrNoSuchKey
+	}
+
+	d.variantMu.Lock()
+	defer d.variantMu.Unlock()
+
+	m, err := d.readMeta(hashHex)
+	if os.IsNotExist(err) {
+		return cache.ErrNoSuchKey
+	} else if err != nil {
+		return err
+	}
+	if m.Eol < time.Now().Unix() {
+		return cache.ErrNoSuchKey
+	}
+
+	variants, err := d.readVariants(hashHex)
+	if os.IsNotExist(err) {
+		return cache.ErrNoSuchKey
+	} else if err != nil {
+		return err
+	}
+	variants[encoding] = content
+
+	size, err := d.writeVariants(hashHex, variants)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.usedBytes += size - e.size
+	e.size = size
+	d.mu.Unlock()
+
+	d.logger.Debugf("cached variant: [url] %s, [enc] %s, [hash] %s", url, encoding, hashHex)
+	return nil
+}
+
+var ErrEntryTooLarge = errors.New("entry larger than cache quota")
+
+// makeRoom evicts least-recently-used entries until there is space for
+// size more bytes, honouring MaxBytes. MaxBytes <= 0 disables the quota.
+func (d *DiskStore) makeRoom(size int64) error {
+	if d.maxBytes <= 0 {
+		return nil
+	}
+	if size > d.maxBytes {
+		return ErrEntryTooLarge
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.usedBytes+size <= d.maxBytes {
+		return nil
+	}
+
+	type candidate struct {
+		hash string
+		e    *diskEntry
+	}
+	lru := make([]candidate, 0, len(d.entries))
+	for hash, e := range d.entries {
+		lru = append(lru, candidate{hash: hash, e: e})
+	}
+	sort.Slice(lru, func(i, j int) bool {
+		return lru[i].e.lastAccess.Before(lru[j].e.lastAccess)
+	})
+
+	for _, c := range lru {
+		if d.usedBytes+size <= d.maxBytes {
+			break
+		}
+
+		hashHex := hex.EncodeToString([]byte(c.hash))
+		d.removeFiles(hashHex)
+		d.usedBytes -= c.e.size
+		delete(d.entries, c.hash)
+		d.logger.Debugf("evicted for quota: %s", hashHex)
+	}
+
+	return nil
+}
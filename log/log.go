@@ -0,0 +1,15 @@
+// Package log defines the minimal logger interface the rest of this
+// tree depends on, so packages can accept whatever logger a caller
+// already has (e.g. echo.Echo.Logger, whose default value is a
+// *github.com/labstack/gommon/log.Logger) without importing a concrete
+// logging library themselves.
+package log
+
+type Logger interface {
+	Debug(i ...any)
+	Debugf(format string, args ...any)
+	Info(i ...any)
+	Infof(format string, args ...any)
+	Error(i ...any)
+	Errorf(format string, args ...any)
+}
@@ -3,7 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"ouchi/cache"
+	"ouchi/disk"
+	"ouchi/memcachedstore"
 	"ouchi/memory"
+	"ouchi/peercache"
+	"ouchi/redisstore"
 	"ouchi/ttlcache"
 	"path"
 	"time"
@@ -13,6 +18,26 @@ import (
 	"github.com/labstack/gommon/log"
 )
 
+// newStore builds the cache.ChacheStore named by config.Backend, so the
+// caching behavior of the proxy can be changed without touching code.
+func newStore(logger echo.Logger, config *ttlcache.ConfigFile) (cache.ChacheStore, error) {
+	ttlSec := time.Second * config.TtlSec
+	tickSec := time.Second * config.TickSec
+
+	switch config.Backend {
+	case "", ttlcache.BackendMemory:
+		return memory.NewMemoryStore(logger, tickSec, ttlSec), nil
+	case ttlcache.BackendDisk:
+		return disk.NewDiskStore(logger, config.Disk.Dir, config.Disk.MaxBytes, tickSec, ttlSec)
+	case ttlcache.BackendRedis:
+		return redisstore.NewRedisStore(logger, config.Redis, ttlSec), nil
+	case ttlcache.BackendMemcached:
+		return memcachedstore.NewMemcachedStore(logger, config.Memcached, ttlSec), nil
+	default:
+		return nil, fmt.Errorf("unknown backend: %s", config.Backend)
+	}
+}
+
 func main() {
 	debug := flag.Bool("debug", false, "use debug mode")
 	configPath := flag.String("config", "config.json", "configuration json file")
@@ -32,18 +57,29 @@ func main() {
 		e.Logger.Fatal(err)
 	}
 
-	store := memory.NewMemoryStore(
-		e.Logger,
-		time.Second*config.TickSec,
-		time.Second*config.TtlSec,
-	)
+	store, err := newStore(e.Logger, config)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
 
 	cache, err := ttlcache.NewTtlCache(
 		e.Logger,
 		store,
 		fmt.Sprintf("http://localhost:%d", config.OriginPort),
 		config.Headers,
+		config.StreamThresholdBytes,
+		config.MaxCacheableBytes,
+		config.Policies,
+		config.Compression,
+		config.MinCompressBytes,
+		config.Self,
+		config.Peers,
 	)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+
+	e.GET(peercache.PeerPath, cache.PeerHandler())
 
 	originGroup := e.Group("/*")
 	originGroup.Use(cache.Middleware())
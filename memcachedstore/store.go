@@ -0,0 +1,166 @@
+// Package memcachedstore implements cache.ChacheStore on top of
+// Memcached, relying on native key TTL instead of the sorted-slice
+// sweeper that memory.MemoryStore and disk.DiskStore need.
+package memcachedstore
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"ouchi/cache"
+	"ouchi/log"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// Config is the `memcached` block of ttlcache.ConfigFile.
+type Config struct {
+	Servers []string `json:"servers"`
+}
+
+type MemcachedStore struct {
+	logger log.Logger
+	client *memcache.Client
+	ttlSec time.Duration
+
+	// variantMu guards the read-decode-mutate-encode-set round trip done
+	// by SetVariant, since a gzip and a brotli variant for the same
+	// entry can be staged concurrently.
+	variantMu sync.Mutex
+}
+
+func NewMemcachedStore(logger log.Logger, config Config, ttlSec time.Duration) *MemcachedStore {
+	return &MemcachedStore{
+		logger: logger,
+		client: memcache.New(config.Servers...),
+		ttlSec: ttlSec,
+	}
+}
+
+// memcached keys must be short, printable and whitespace-free, so the
+// raw FNV-128a bytes are hex-encoded before use, the same as disk.DiskStore
+// does for filenames.
+func memcachedKey(hash string) string {
+	return hex.EncodeToString([]byte(hash))
+}
+
+func (m *MemcachedStore) Get(url string) (*cache.ChacheData, error) {
+	m.logger.Debugf("looking for %s", url)
+
+	hash, err := cache.HashKey(url)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := m.client.Get(memcachedKey(hash))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, cache.ErrNoSuchKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	d := &cache.ChacheData{}
+	if err := gob.NewDecoder(bytes.NewReader(item.Value)).Decode(d); err != nil {
+		return nil, err
+	}
+
+	m.logger.Debugf("found cache: %s", url)
+	return d, nil
+}
+
+func (m *MemcachedStore) Set(
+	url string,
+	contentType string,
+	content []byte,
+	ttl time.Duration,
+) error {
+	if ttl <= 0 {
+		ttl = m.ttlSec
+	}
+
+	hash, err := cache.HashKey(url)
+	if err != nil {
+		return err
+	}
+
+	d := cache.ChacheData{
+		Eol:         time.Now().Add(ttl).Unix(),
+		ContentType: contentType,
+		Variants:    map[string][]byte{cache.IdentityEncoding: content},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(d); err != nil {
+		return err
+	}
+
+	if err := m.client.Set(&memcache.Item{
+		Key:        memcachedKey(hash),
+		Value:      buf.Bytes(),
+		Expiration: int32(ttl.Seconds()),
+	}); err != nil {
+		return err
+	}
+
+	m.logger.Debugf(
+		"cached: [url] %s, [type] %s",
+		url,
+		contentType,
+	)
+	return nil
+}
+
+// SetVariant adds or replaces one pre-compressed representation of an
+// already-cached entry, re-setting it with the remaining TTL derived
+// from the stored Eol - memcached's protocol has no "get remaining ttl"
+// of its own. variantMu serializes the whole get-decode-mutate-encode-set
+// round trip so concurrent variants for the same key don't silently
+// clobber one another.
+func (m *MemcachedStore) SetVariant(url string, encoding string, content []byte) error {
+	hash, err := cache.HashKey(url)
+	if err != nil {
+		return err
+	}
+
+	m.variantMu.Lock()
+	defer m.variantMu.Unlock()
+
+	item, err := m.client.Get(memcachedKey(hash))
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return cache.ErrNoSuchKey
+	} else if err != nil {
+		return err
+	}
+
+	d := &cache.ChacheData{}
+	if err := gob.NewDecoder(bytes.NewReader(item.Value)).Decode(d); err != nil {
+		return err
+	}
+
+	remaining := time.Until(time.Unix(d.Eol, 0))
+	// Memcached expirations are whole seconds and 0 means "never expire",
+	// so less than a second left is treated as already expired.
+	if remaining < time.Second {
+		return cache.ErrNoSuchKey
+	}
+	d.Variants[encoding] = content
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(d); err != nil {
+		return err
+	}
+
+	if err := m.client.Set(&memcache.Item{
+		Key:        memcachedKey(hash),
+		Value:      buf.Bytes(),
+		Expiration: int32(remaining.Seconds()),
+	}); err != nil {
+		return err
+	}
+
+	m.logger.Debugf("cached variant: [url] %s, [enc] %s", url, encoding)
+	return nil
+}
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"ouchi/cache"
 	"ouchi/log"
-	"slices"
 	"sync"
 	"time"
 )
@@ -14,10 +13,13 @@ type MemoryStore struct {
 
 	cacheMap sync.Map
 	ttlSec   time.Duration
-	tickSec  time.Duration
-}
 
-const EOL_DATA_KEY = "EOL_DATA_KEY"
+	eolIndex *cache.EolIndex
+
+	// variantMu guards read-modify-write of a ChacheData's Variants map,
+	// since SetVariant mutates an entry already stored in cacheMap.
+	variantMu sync.Mutex
+}
 
 func NewMemoryStore(
 	logger log.Logger,
@@ -29,51 +31,18 @@ func NewMemoryStore(
 
 		cacheMap: sync.Map{},
 		ttlSec:   ttlSec,
-		tickSec:  tickSec,
-	}
 
-	// store sorted slice at key of 0
-	m.cacheMap.Store(EOL_DATA_KEY, make([]cache.EolData, 0))
+		eolIndex: cache.NewEolIndex(),
+	}
 
-	go m.cleaning()
+	cache.StartSweeping(tickSec, m.eolIndex, func(key string) {
+		m.cacheMap.Delete(key)
+		m.logger.Debugf("deleted: %s", key)
+	})
 
 	return m
 }
 
-func (m *MemoryStore) cleaning() {
-	ticker := time.Tick(m.tickSec)
-
-	for t := range ticker {
-		m.logger.Debugf("cleaning... now: %s", t)
-		now := t.Unix()
-
-		s, ok := m.cacheMap.Load(EOL_DATA_KEY)
-		if !ok {
-			m.logger.Error("failed to load sorted eol list")
-			continue
-		}
-		sorted, ok := s.([]cache.EolData)
-		if !ok {
-			m.logger.Error("failed to cast sorted eol list")
-			continue
-		}
-
-		for _, eolData := range sorted {
-			if eolData.Eol >= now {
-				break
-			}
-
-			m.cacheMap.Delete(eolData.Key)
-			m.logger.Debugf("deleted: %s", eolData.Key)
-		}
-
-		sorted = slices.DeleteFunc(sorted, func(eolData cache.EolData) bool {
-			return eolData.Eol < now
-		})
-		m.cacheMap.Store(EOL_DATA_KEY, sorted)
-	}
-}
-
 func (m *MemoryStore) Get(url string) (*cache.ChacheData, error) {
 	m.logger.Debugf("looking for %s", url)
 
@@ -103,15 +72,17 @@ func (m *MemoryStore) Get(url string) (*cache.ChacheData, error) {
 func (m *MemoryStore) Set(
 	url string,
 	contentType string,
-	contentEncoding string,
 	content []byte,
+	ttl time.Duration,
 ) error {
-	eol := time.Now().Add(m.ttlSec).Unix()
+	if ttl <= 0 {
+		ttl = m.ttlSec
+	}
+	eol := time.Now().Add(ttl).Unix()
 	d := &cache.ChacheData{
-		Eol:             eol,
-		ContentType:     contentType,
-		ContentEncoding: contentEncoding,
-		Data:            content,
+		Eol:         eol,
+		ContentType: contentType,
+		Variants:    map[string][]byte{cache.IdentityEncoding: content},
 	}
 
 	hash, err := cache.HashKey(url)
@@ -119,29 +90,40 @@ func (m *MemoryStore) Set(
 		return err
 	}
 
-	s, ok := m.cacheMap.Load(EOL_DATA_KEY)
-	if !ok {
-		return errors.New("could not find key for sorted eol list")
-	}
-	sorted, ok := s.([]cache.EolData)
-	if !ok {
-		return errors.New("failed to cast sorted eol list")
-	}
-
-	sorted = append(sorted, cache.EolData{
-		Key: hash,
-		Eol: eol,
-	})
-	slices.SortFunc(sorted, cache.SortEolData)
-	m.cacheMap.Store(EOL_DATA_KEY, sorted)
-
+	m.eolIndex.Add(hash, eol)
 	m.cacheMap.Store(hash, d)
 	m.logger.Debugf(
-		"cached: [url] %s, [type] %s, [enc] %s, [hash] %s",
+		"cached: [url] %s, [type] %s, [hash] %s",
 		url,
 		contentType,
-		contentEncoding,
 		hash,
 	)
 	return nil
 }
+
+func (m *MemoryStore) SetVariant(url string, encoding string, content []byte) error {
+	hash, err := cache.HashKey(url)
+	if err != nil {
+		return err
+	}
+
+	v, ok := m.cacheMap.Load(hash)
+	if !ok {
+		return cache.ErrNoSuchKey
+	}
+	d, ok := v.(*cache.ChacheData)
+	if !ok {
+		return errors.New("failed to acquire value as expexted structure type")
+	}
+
+	m.variantMu.Lock()
+	defer m.variantMu.Unlock()
+
+	if d.Eol < time.Now().Unix() {
+		return cache.ErrNoSuchKey
+	}
+	d.Variants[encoding] = content
+
+	m.logger.Debugf("cached variant: [url] %s, [enc] %s, [hash] %s", url, encoding, hash)
+	return nil
+}
@@ -1,13 +1,12 @@
 package memory
 
 import (
-	"bytes"
 	"errors"
 	"hash/fnv"
-	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"ouchi/log"
 	"ouchi/ttlcache"
 	"slices"
 	"strings"
@@ -23,9 +22,12 @@ type MemoryTtlCache struct {
 	headers  map[string]string
 	cacheMap sync.Map
 
-	logger   ttlcache.Logger
+	logger   log.Logger
 	proxyUrl *url.URL
 	proxy    *httputil.ReverseProxy
+
+	streamThreshold   int64
+	maxCacheableBytes int64
 }
 
 const EOL_DATA_KEY = "EOL_DATA_KEY"
@@ -46,6 +48,9 @@ func NewMemoryTtlCache(config ttlcache.TtlCacheConfig) (*MemoryTtlCache, error)
 		logger:   config.Logger,
 		proxyUrl: proxyUrl,
 		proxy:    proxy,
+
+		streamThreshold:   config.StreamThreshold,
+		maxCacheableBytes: config.MaxCacheableBytes,
 	}
 
 	// Use modifier for reading and caching response
@@ -97,22 +102,18 @@ func (c *MemoryTtlCache) onProxyResponse(res *http.Response) error {
 		h := res.Header
 		cacheControl := h.Get("Cache-Control")
 		if cacheControl != "no-cache" && cacheControl != "no-store" {
-			body, err := io.ReadAll(res.Body)
-			if err != nil {
-				return err
-			}
-			// Close now to set new body
-			res.Body.Close()
-
-			go c.cacheResponse(
-				res.Request.URL.RequestURI(),
-				h.Get("Content-Type"),
-				h.Get("Content-Encoding"),
-				body,
+			uri := res.Request.URL.RequestURI()
+			contentType := h.Get("Content-Type")
+			contentEncoding := h.Get("Content-Encoding")
+
+			res.Body = ttlcache.WrapCachingBody(
+				res.Body,
+				c.streamThreshold,
+				c.maxCacheableBytes,
+				func(body []byte) {
+					go c.cacheResponse(uri, contentType, contentEncoding, body)
+				},
 			)
-
-			// Set body again. better way ??
-			res.Body = io.NopCloser(bytes.NewReader(body))
 		}
 	}
 
@@ -146,25 +147,21 @@ func (c *MemoryTtlCache) middlewareHandler(next echo.HandlerFunc) echo.HandlerFu
 			return nil
 		}
 
-		cache, err := c.get(req.URL.RequestURI())
-		// Cache miss - proxy the request
-		if errors.Is(err, ttlcache.ErrNoSuchKey) || errors.Is(err, ttlcache.ErrExpired) {
-			c.logger.Debug(err)
-			req.Host = c.proxyUrl.Hostname()
-			c.proxy.ServeHTTP(ctx.Response(), req)
-			c.setHeaders(ctx, "", false)
-			return nil
-		} else if err != nil {
+		uri := req.URL.RequestURI()
+		cache, err := c.get(uri)
+		if err == nil {
+			c.setHeaders(ctx, cache.ContentEncoding, true)
+			return ctx.Blob(http.StatusOK, cache.ContentType, cache.Data)
+		}
+		if !errors.Is(err, ttlcache.ErrNoSuchKey) && !errors.Is(err, ttlcache.ErrExpired) {
 			return err
 		}
+		c.logger.Debug(err)
 
-		c.setHeaders(ctx, cache.ContentEncoding, true)
-
-		return ctx.Blob(
-			http.StatusOK,
-			cache.ContentType,
-			cache.Data,
-		)
+		req.Host = c.proxyUrl.Hostname()
+		c.proxy.ServeHTTP(ctx.Response(), req)
+		c.setHeaders(ctx, "", false)
+		return nil
 	}
 }
 
@@ -0,0 +1,124 @@
+// Package peercache turns a set of ouchi-cdn instances into a single
+// distributed cache, groupcache-style: a consistent hash ring decides
+// which node owns a given URI, and only the owner ever fetches that URI
+// from origin. Other nodes relay to the owner over HTTP instead of
+// hitting origin themselves.
+package peercache
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"ouchi/log"
+	"sync"
+	"time"
+)
+
+// PeerPath is the internal route a node exposes so other peers can ask
+// it for the URI it owns.
+const PeerPath = "/_ouchi/peer/:hash"
+
+var ErrPeerUnreachable = errors.New("peer unreachable")
+
+// PeerPool knows this node's own address, the rest of the cluster, and
+// how to route a request's hash to whichever one of them owns it.
+type PeerPool struct {
+	self string
+
+	mu   sync.RWMutex
+	ring *hashRing
+
+	client *http.Client
+	group  *Group
+
+	logger log.Logger
+}
+
+func NewPeerPool(self string, peers []string, logger log.Logger) *PeerPool {
+	return &PeerPool{
+		self: self,
+
+		ring: newHashRing(append([]string{self}, peers...)),
+
+		client: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		group: NewGroup(),
+
+		logger: logger,
+	}
+}
+
+// SetPeers reconfigures the ring at runtime, e.g. when the cluster scales
+// up or down. peers does not include self; self is always added to the
+// ring implicitly.
+func (p *PeerPool) SetPeers(peers []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.ring = newHashRing(append([]string{p.self}, peers...))
+	p.logger.Debugf("peer ring updated: %v", peers)
+}
+
+// PickOwner returns the peer that owns hash, and whether that peer is
+// this node itself.
+func (p *PeerPool) PickOwner(hash string) (owner string, isSelf bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	owner, ok := p.ring.get(hash)
+	if !ok || owner == p.self {
+		return p.self, true
+	}
+	return owner, false
+}
+
+// FetchFromPeer asks owner for uri, collapsing concurrent requests for
+// the same hash into a single outbound call. Callers should fall back to
+// a local origin fetch when this returns an error.
+func (p *PeerPool) FetchFromPeer(owner, hash, uri string) ([]byte, http.Header, error) {
+	v, err := p.group.Do(owner+"|"+hash, func() (any, error) {
+		return p.doFetch(owner, hash, uri)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r := v.(*peerResponse)
+	return r.body, r.header, nil
+}
+
+type peerResponse struct {
+	body   []byte
+	header http.Header
+}
+
+func (p *PeerPool) doFetch(owner, hash, uri string) (*peerResponse, error) {
+	target := fmt.Sprintf(
+		"%s/_ouchi/peer/%s?uri=%s",
+		owner,
+		hex.EncodeToString([]byte(hash)),
+		url.QueryEscape(uri),
+	)
+
+	res, err := p.client.Get(target)
+	if err != nil {
+		p.logger.Errorf("peer %s unreachable: %s", owner, err)
+		return nil, ErrPeerUnreachable
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %s returned %d", ErrPeerUnreachable, owner, res.StatusCode)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &peerResponse{body: body, header: res.Header}, nil
+}
@@ -0,0 +1,74 @@
+package peercache
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// replicas is the number of virtual nodes placed on the ring per peer.
+// More replicas spread load more evenly across a small peer set, at the
+// cost of a slightly larger ring to binary-search.
+const replicas = 160
+
+// hashRing is a ketama-style consistent hash ring. It maps a key's 32-bit
+// FNV-32a position to the peer owning that position, with
+// each peer occupying `replicas` virtual nodes so that adding or removing
+// a peer only reshuffles a small fraction of keys.
+type hashRing struct {
+	positions []uint32
+	owners    map[uint32]string
+}
+
+func newHashRing(peers []string) *hashRing {
+	r := &hashRing{
+		owners: make(map[uint32]string),
+	}
+	r.set(peers)
+	return r
+}
+
+func (r *hashRing) set(peers []string) {
+	positions := make([]uint32, 0, len(peers)*replicas)
+	owners := make(map[uint32]string, len(peers)*replicas)
+
+	for _, peer := range peers {
+		for i := 0; i < replicas; i++ {
+			pos := ringHash(strconv.Itoa(i) + peer)
+			owners[pos] = peer
+			positions = append(positions, pos)
+		}
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+
+	r.positions = positions
+	r.owners = owners
+}
+
+func (r *hashRing) isEmpty() bool {
+	return len(r.positions) == 0
+}
+
+// get returns the peer that owns key, walking clockwise from key's
+// position to the nearest virtual node.
+func (r *hashRing) get(key string) (string, bool) {
+	if r.isEmpty() {
+		return "", false
+	}
+
+	pos := ringHash(key)
+	idx := sort.Search(len(r.positions), func(i int) bool {
+		return r.positions[i] >= pos
+	})
+	if idx == len(r.positions) {
+		idx = 0
+	}
+
+	return r.owners[r.positions[idx]], true
+}
+
+func ringHash(s string) uint32 {
+	hasher := fnv.New32a()
+	_, _ = hasher.Write([]byte(s))
+	return hasher.Sum32()
+}
@@ -0,0 +1,48 @@
+package peercache
+
+import "sync"
+
+// call is an in-flight or completed fetch.
+type call struct {
+	wg  sync.WaitGroup
+	val any
+	err error
+}
+
+// Group collapses concurrent calls for the same key into a single
+// execution of fn, handing every caller the one result. It exists so
+// that a burst of misses for the same URI only ever reaches origin once,
+// instead of once per waiting request.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+func NewGroup() *Group {
+	return &Group{
+		calls: make(map[string]*call),
+	}
+}
+
+func (g *Group) Do(key string, fn func() (any, error)) (any, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+
+	c := new(call)
+	c.wg.Add(1)
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	c.val, c.err = fn()
+	c.wg.Done()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	return c.val, c.err
+}
@@ -0,0 +1,157 @@
+// Package redisstore implements cache.ChacheStore on top of Redis,
+// relying on native key TTL instead of the sorted-slice sweeper that
+// memory.MemoryStore and disk.DiskStore need.
+package redisstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"ouchi/cache"
+	"ouchi/log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Config is the `redis` block of ttlcache.ConfigFile.
+type Config struct {
+	Addr     string `json:"addr"`
+	Password string `json:"password"`
+	DB       int    `json:"db"`
+}
+
+type RedisStore struct {
+	logger log.Logger
+	client *redis.Client
+	ttlSec time.Duration
+
+	// variantMu guards the read-decode-mutate-encode-set round trip done
+	// by SetVariant, since a gzip and a brotli variant for the same
+	// entry can be staged concurrently.
+	variantMu sync.Mutex
+}
+
+func NewRedisStore(logger log.Logger, config Config, ttlSec time.Duration) *RedisStore {
+	client := redis.NewClient(&redis.Options{
+		Addr:     config.Addr,
+		Password: config.Password,
+		DB:       config.DB,
+	})
+
+	return &RedisStore{
+		logger: logger,
+		client: client,
+		ttlSec: ttlSec,
+	}
+}
+
+func (r *RedisStore) Get(url string) (*cache.ChacheData, error) {
+	r.logger.Debugf("looking for %s", url)
+
+	hash, err := cache.HashKey(url)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := r.client.Get(context.Background(), hash).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, cache.ErrNoSuchKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	d := &cache.ChacheData{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(d); err != nil {
+		return nil, err
+	}
+
+	r.logger.Debugf("found cache: %s", url)
+	return d, nil
+}
+
+func (r *RedisStore) Set(
+	url string,
+	contentType string,
+	content []byte,
+	ttl time.Duration,
+) error {
+	if ttl <= 0 {
+		ttl = r.ttlSec
+	}
+
+	hash, err := cache.HashKey(url)
+	if err != nil {
+		return err
+	}
+
+	d := cache.ChacheData{
+		Eol:         time.Now().Add(ttl).Unix(),
+		ContentType: contentType,
+		Variants:    map[string][]byte{cache.IdentityEncoding: content},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(d); err != nil {
+		return err
+	}
+
+	if err := r.client.Set(context.Background(), hash, buf.Bytes(), ttl).Err(); err != nil {
+		return err
+	}
+
+	r.logger.Debugf(
+		"cached: [url] %s, [type] %s",
+		url,
+		contentType,
+	)
+	return nil
+}
+
+// SetVariant adds or replaces one pre-compressed representation of an
+// already-cached entry, re-setting the key with its remaining TTL
+// derived from the stored Eol rather than querying Redis for it.
+// variantMu serializes the whole get-decode-mutate-encode-set round trip
+// so concurrent variants for the same key don't silently clobber one
+// another.
+func (r *RedisStore) SetVariant(url string, encoding string, content []byte) error {
+	hash, err := cache.HashKey(url)
+	if err != nil {
+		return err
+	}
+
+	r.variantMu.Lock()
+	defer r.variantMu.Unlock()
+
+	b, err := r.client.Get(context.Background(), hash).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return cache.ErrNoSuchKey
+	} else if err != nil {
+		return err
+	}
+
+	d := &cache.ChacheData{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(d); err != nil {
+		return err
+	}
+
+	remaining := time.Until(time.Unix(d.Eol, 0))
+	if remaining <= 0 {
+		return cache.ErrNoSuchKey
+	}
+	d.Variants[encoding] = content
+
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(d); err != nil {
+		return err
+	}
+
+	if err := r.client.Set(context.Background(), hash, buf.Bytes(), remaining).Err(); err != nil {
+		return err
+	}
+
+	r.logger.Debugf("cached variant: [url] %s, [enc] %s", url, encoding)
+	return nil
+}
@@ -0,0 +1,186 @@
+package ttlcache
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// ChunkedCacheWriter stages a response body for caching without holding
+// the whole thing in memory up front: writes go to an in-memory buffer
+// until StreamThreshold is exceeded, at which point it spills to a temp
+// file and every later write goes straight to disk.
+type ChunkedCacheWriter struct {
+	threshold int64
+	written   int64
+
+	buf  *bytes.Buffer
+	file *os.File
+}
+
+// NewChunkedCacheWriter returns a writer that spills to a temp file once
+// more than threshold bytes have been written. threshold <= 0 means
+// never spill.
+func NewChunkedCacheWriter(threshold int64) *ChunkedCacheWriter {
+	return &ChunkedCacheWriter{
+		threshold: threshold,
+		buf:       new(bytes.Buffer),
+	}
+}
+
+func (w *ChunkedCacheWriter) Write(p []byte) (int, error) {
+	if w.file != nil {
+		n, err := w.file.Write(p)
+		w.written += int64(n)
+		return n, err
+	}
+
+	if w.threshold > 0 && w.written+int64(len(p)) > w.threshold {
+		if err := w.spill(); err != nil {
+			return 0, err
+		}
+		return w.Write(p)
+	}
+
+	n, err := w.buf.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *ChunkedCacheWriter) spill() error {
+	f, err := os.CreateTemp("", "ouchi-cache-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(w.buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	w.buf = nil
+	w.file = f
+	return nil
+}
+
+// Written reports how many bytes have been staged so far, without
+// requiring the staged content to be read back.
+func (w *ChunkedCacheWriter) Written() int64 {
+	return w.written
+}
+
+// Bytes returns everything staged so far. Only call it once writing has
+// finished.
+func (w *ChunkedCacheWriter) Bytes() ([]byte, error) {
+	if w.file == nil {
+		return w.buf.Bytes(), nil
+	}
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(w.file)
+}
+
+// Discard releases the staging area. It must be called exactly once,
+// whether or not the staged content ends up cached.
+func (w *ChunkedCacheWriter) Discard() {
+	if w.file != nil {
+		name := w.file.Name()
+		w.file.Close()
+		os.Remove(name)
+		w.file = nil
+	}
+	w.buf = nil
+}
+
+// safeWriter adapts a ChunkedCacheWriter for io.TeeReader, whose
+// documented contract surfaces any write error as a read error on the
+// tee. That would let a cache-side failure (e.g. a full disk at
+// ChunkedCacheWriter.spill) abort the client-facing response, which
+// cache writes must never do. It records the failure instead of
+// returning it, so the tee keeps reading normally and Close discards the
+// staged bytes once it sees err set.
+type safeWriter struct {
+	w   *ChunkedCacheWriter
+	err error
+}
+
+func (s *safeWriter) Write(p []byte) (int, error) {
+	if s.err == nil {
+		if _, err := s.w.Write(p); err != nil {
+			s.err = err
+		}
+	}
+	return len(p), nil
+}
+
+// cachingBody wraps a response body in a TeeReader over a
+// ChunkedCacheWriter, and commits the staged bytes to the cache on
+// Close - which is when the reverse proxy is done copying the body to
+// the client. commit is skipped, and the staging area discarded, on any
+// read error, cache-side write error, when the body was closed before
+// being read to EOF, or when the body turned out to exceed maxBytes.
+type cachingBody struct {
+	tee      io.Reader
+	orig     io.ReadCloser
+	writer   *ChunkedCacheWriter
+	safeW    *safeWriter
+	maxBytes int64
+	commit   func([]byte)
+	readErr  error
+	eof      bool
+}
+
+// WrapCachingBody returns a ReadCloser that proxies body while staging
+// its content into a ChunkedCacheWriter bounded by streamThreshold. If
+// the fully-read body is no larger than maxCacheableBytes (<=0 means
+// unbounded), commit is called with the staged bytes once Close runs;
+// otherwise the staged bytes are discarded and commit is never called.
+func WrapCachingBody(
+	body io.ReadCloser,
+	streamThreshold int64,
+	maxCacheableBytes int64,
+	commit func([]byte),
+) io.ReadCloser {
+	w := NewChunkedCacheWriter(streamThreshold)
+	sw := &safeWriter{w: w}
+	return &cachingBody{
+		tee:      io.TeeReader(body, sw),
+		orig:     body,
+		writer:   w,
+		safeW:    sw,
+		maxBytes: maxCacheableBytes,
+		commit:   commit,
+	}
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.tee.Read(p)
+	if err == io.EOF {
+		b.eof = true
+	} else if err != nil {
+		b.readErr = err
+	}
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	err := b.orig.Close()
+	defer b.writer.Discard()
+
+	if !b.eof || b.readErr != nil || b.safeW.err != nil {
+		return err
+	}
+	if b.maxBytes > 0 && b.writer.Written() > b.maxBytes {
+		return err
+	}
+
+	data, werr := b.writer.Bytes()
+	if werr != nil {
+		return err
+	}
+
+	b.commit(data)
+	return err
+}
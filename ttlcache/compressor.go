@@ -3,15 +3,42 @@ package ttlcache
 import (
 	"bytes"
 	"compress/gzip"
+	"fmt"
 	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
 )
 
-func compress(body io.ReadCloser) ([]byte, error) {
+// Content-Encoding names ChacheData.Variants is keyed on, matching what
+// the HTTP Accept-Encoding/Content-Encoding headers use on the wire.
+const (
+	EncodingGzip   = "gzip"
+	EncodingBrotli = "br"
+
+	defaultBrotliLevel = 5
+)
+
+// CompressionLevels configures the on-the-fly (de)compression added to
+// onProxyResponse/middlewareHandler. A <=0 level means "use the
+// package's default".
+type CompressionLevels struct {
+	Gzip   int `json:"gzip"`
+	Brotli int `json:"brotli"`
+}
+
+func compress(body io.ReadCloser, level int) ([]byte, error) {
 	defer body.Close()
 
+	if level <= 0 {
+		level = gzip.DefaultCompression
+	}
+
 	buff := new(bytes.Buffer)
-	g := gzip.NewWriter(buff)
-	defer g.Close()
+	g, err := gzip.NewWriterLevel(buff, level)
+	if err != nil {
+		return nil, err
+	}
 
 	in, err := io.ReadAll(body)
 	if err != nil {
@@ -20,9 +47,79 @@ func compress(body io.ReadCloser) ([]byte, error) {
 	if _, err := g.Write(in); err != nil {
 		return nil, err
 	}
-	if err := g.Flush(); err != nil {
+	if err := g.Close(); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+func compressBrotli(body io.ReadCloser, level int) ([]byte, error) {
+	defer body.Close()
+
+	if level <= 0 {
+		level = defaultBrotliLevel
+	}
+
+	buff := new(bytes.Buffer)
+	b := brotli.NewWriterLevel(buff, level)
+
+	in, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := b.Write(in); err != nil {
+		return nil, err
+	}
+	if err := b.Close(); err != nil {
 		return nil, err
 	}
 
 	return buff.Bytes(), nil
 }
+
+// decompress reverses compress/compressBrotli, returning content
+// unchanged for the identity encoding.
+func decompress(encoding string, content []byte) ([]byte, error) {
+	switch encoding {
+	case "", "identity":
+		return content, nil
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case EncodingBrotli:
+		return io.ReadAll(brotli.NewReader(bytes.NewReader(content)))
+	default:
+		return nil, fmt.Errorf("unsupported content-encoding: %s", encoding)
+	}
+}
+
+// acceptedEncodings parses an Accept-Encoding header into the
+// compressed encodings it accepts, brotli before gzip, ignoring
+// q-values - good enough for picking a stored variant or an on-demand
+// fallback, not for full RFC 7231 conformance.
+func acceptedEncodings(acceptEncoding string) []string {
+	var gzipOk, brotliOk bool
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		token, _, _ := strings.Cut(strings.TrimSpace(part), ";")
+		switch token {
+		case EncodingGzip:
+			gzipOk = true
+		case EncodingBrotli:
+			brotliOk = true
+		}
+	}
+
+	var encodings []string
+	if brotliOk {
+		encodings = append(encodings, EncodingBrotli)
+	}
+	if gzipOk {
+		encodings = append(encodings, EncodingGzip)
+	}
+	return encodings
+}
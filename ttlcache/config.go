@@ -3,15 +3,71 @@ package ttlcache
 import (
 	"encoding/json"
 	"os"
+	"ouchi/memcachedstore"
+	"ouchi/redisstore"
 	"time"
 )
 
+// Backend names accepted by ConfigFile.Backend. The empty string is
+// equivalent to BackendMemory, so existing config files keep working.
+const (
+	BackendMemory    = "memory"
+	BackendDisk      = "disk"
+	BackendRedis     = "redis"
+	BackendMemcached = "memcached"
+)
+
 type ConfigFile struct {
 	Headers    map[string]string `json:"headers"`
 	TtlSec     time.Duration     `json:"ttl_sec"`
 	TickSec    time.Duration     `json:"tick_sec"`
 	OriginPort uint16            `json:"origin_port"`
 	ListenPort uint16            `json:"listen_port"`
+
+	// StreamThresholdBytes is how much of an origin response
+	// ChunkedCacheWriter stages in memory before spilling to a temp
+	// file. <=0 means never spill.
+	StreamThresholdBytes int64 `json:"stream_threshold_bytes"`
+	// MaxCacheableBytes bypasses caching, without affecting proxying,
+	// for responses larger than this. <=0 means unbounded.
+	MaxCacheableBytes int64 `json:"max_cacheable_bytes"`
+
+	// Compression sets the gzip/brotli levels used both to precompute
+	// variants after a miss and to compress on demand for a client whose
+	// Accept-Encoding doesn't match any stored variant.
+	Compression CompressionLevels `json:"compression"`
+	// MinCompressBytes skips compression, storing identity only, for
+	// responses smaller than this - not worth the CPU for tiny bodies.
+	MinCompressBytes int64 `json:"min_compress_bytes"`
+
+	// Backend selects the cache.ChacheStore implementation. Defaults to
+	// BackendMemory when empty. Only the config block matching Backend
+	// needs to be set.
+	Backend string `json:"backend"`
+
+	Disk      DiskConfig            `json:"disk"`
+	Redis     redisstore.Config     `json:"redis"`
+	Memcached memcachedstore.Config `json:"memcached"`
+
+	// Policies are evaluated in order to decide whether a request looks
+	// up and stores to the cache, and with what TTL and cache key. The
+	// first matching policy wins; with no match, only GET/HEAD requests
+	// are cached.
+	Policies []Policy `json:"policies"`
+
+	// Self and Peers configure peer-to-peer caching: Self is this node's
+	// own address (as the rest of the cluster would reach it) and Peers
+	// is the full peer set excluding Self. Leaving Peers empty keeps the
+	// cache a plain single-node instance.
+	Self  string   `json:"self"`
+	Peers []string `json:"peers"`
+}
+
+// DiskConfig is the `disk` block of ConfigFile, used when Backend is
+// BackendDisk.
+type DiskConfig struct {
+	Dir      string `json:"dir"`
+	MaxBytes int64  `json:"max_bytes"`
 }
 
 func ReadConfigFile(configFile string) (*ConfigFile, error) {
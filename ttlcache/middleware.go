@@ -5,10 +5,12 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
 	"ouchi/cache"
 	"ouchi/log"
+	"ouchi/peercache"
 
 	"github.com/labstack/echo/v4"
 )
@@ -20,6 +22,30 @@ type TtlCache struct {
 	proxy    *httputil.ReverseProxy
 
 	headers map[string]string
+
+	// streamThreshold is how many bytes of an origin response are
+	// staged in memory before ChunkedCacheWriter spills to a temp file.
+	streamThreshold int64
+	// maxCacheableBytes bypasses caching, without affecting proxying,
+	// for responses larger than this. <=0 means unbounded.
+	maxCacheableBytes int64
+
+	// policies decide, per request path and method, whether to cache at
+	// all, what TTL to use, and what headers to fold into the cache key.
+	policies []Policy
+
+	// compression sets the gzip/brotli levels used both to precompute
+	// variants after a miss and to compress on demand.
+	compression CompressionLevels
+	// minCompressBytes skips precomputing variants for bodies smaller
+	// than this.
+	minCompressBytes int64
+
+	// pool is nil for a single-node deployment; non-nil once the config
+	// lists peers, at which point only the hash ring owner of a key
+	// fetches it from origin and everyone else relays through the pool.
+	pool  *peercache.PeerPool
+	group *peercache.Group
 }
 
 func NewTtlCache(
@@ -27,6 +53,13 @@ func NewTtlCache(
 	store cache.ChacheStore,
 	proxyUrl string,
 	headers map[string]string,
+	streamThreshold int64,
+	maxCacheableBytes int64,
+	policies []Policy,
+	compression CompressionLevels,
+	minCompressBytes int64,
+	self string,
+	peers []string,
 ) (*TtlCache, error) {
 	p, err := url.Parse(proxyUrl)
 	if err != nil {
@@ -41,6 +74,20 @@ func NewTtlCache(
 		proxy:    proxy,
 
 		headers: headers,
+
+		streamThreshold:   streamThreshold,
+		maxCacheableBytes: maxCacheableBytes,
+
+		policies: policies,
+
+		compression:      compression,
+		minCompressBytes: minCompressBytes,
+
+		group: peercache.NewGroup(),
+	}
+
+	if len(peers) != 0 {
+		c.pool = peercache.NewPeerPool(self, peers, logger)
 	}
 
 	// use modifier for reading and caching response
@@ -59,22 +106,40 @@ func (c *TtlCache) onProxyResponse(res *http.Response) error {
 	if res.StatusCode == http.StatusOK {
 		h := res.Header
 		cacheControl := h.Get("Cache-Control")
-		if cacheControl != "no-cache" && cacheControl != "no-store" {
+
+		req := res.Request
+		ttl, ok := cacheable(c.policies, req.URL.Path, req.Method)
+
+		if cacheControl != "no-cache" && cacheControl != "no-store" && ok {
 			contentType := h.Get("Content-Type")
-			b, err := io.ReadAll(res.Body)
-			if err != nil {
-				return err
-			}
+			originEncoding := h.Get("Content-Encoding")
+			key := cacheKey(c.policies, req.URL.RequestURI(), req.URL.Path, req.Method, req.Header)
 
-			if err := c.store.Set(
-				res.Request.URL.RequestURI(),
-				contentType,
-				b,
-			); err != nil {
-				return err
-			}
+			res.Body = WrapCachingBody(
+				res.Body,
+				c.streamThreshold,
+				c.maxCacheableBytes,
+				func(body []byte) {
+					// The client already got origin's bytes as-is; what
+					// we store is decoded to identity so any client can
+					// be served the encoding it actually supports.
+					identity, err := decompress(originEncoding, body)
+					if err != nil {
+						c.logger.Error("failed to decode origin body for caching", err)
+						return
+					}
 
-			res.Body = io.NopCloser(bytes.NewReader(b))
+					// Cache fail should never affect the response.
+					if err := c.store.Set(key, contentType, identity, ttl); err != nil {
+						c.logger.Error("failed to set cache", err)
+						return
+					}
+
+					if int64(len(identity)) >= c.minCompressBytes {
+						go c.precomputeVariants(key, identity)
+					}
+				},
+			)
 		}
 
 		h.Set("X-Ouchi-Cache", "miss")
@@ -84,17 +149,190 @@ func (c *TtlCache) onProxyResponse(res *http.Response) error {
 	return nil
 }
 
+// precomputeVariants compresses identity with every configured encoding
+// and stores the results alongside the identity entry already cached by
+// onProxyResponse, so a later request can be served its preferred
+// encoding without compressing on demand.
+func (c *TtlCache) precomputeVariants(key string, identity []byte) {
+	gz, err := compress(io.NopCloser(bytes.NewReader(identity)), c.compression.Gzip)
+	if err != nil {
+		c.logger.Error("failed to precompute gzip variant", err)
+	} else if err := c.store.SetVariant(key, EncodingGzip, gz); err != nil && !errors.Is(err, cache.ErrNoSuchKey) {
+		c.logger.Error("failed to cache gzip variant", err)
+	}
+
+	br, err := compressBrotli(io.NopCloser(bytes.NewReader(identity)), c.compression.Brotli)
+	if err != nil {
+		c.logger.Error("failed to precompute brotli variant", err)
+	} else if err := c.store.SetVariant(key, EncodingBrotli, br); err != nil && !errors.Is(err, cache.ErrNoSuchKey) {
+		c.logger.Error("failed to cache brotli variant", err)
+	}
+}
+
+// pickVariant returns the best representation of d already cached for
+// acceptEncoding, preferring brotli over gzip over identity.
+func pickVariant(d *cache.ChacheData, acceptEncoding string) (encoding string, content []byte, ok bool) {
+	for _, enc := range acceptedEncodings(acceptEncoding) {
+		if b, exists := d.Variants[enc]; exists {
+			return enc, b, true
+		}
+	}
+	b, exists := d.Variants[cache.IdentityEncoding]
+	return cache.IdentityEncoding, b, exists
+}
+
+// compressOnDemand compresses identity with encoding, used when a
+// client accepts an encoding no variant has been precomputed for yet.
+func (c *TtlCache) compressOnDemand(encoding string, identity []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		return compress(io.NopCloser(bytes.NewReader(identity)), c.compression.Gzip)
+	case EncodingBrotli:
+		return compressBrotli(io.NopCloser(bytes.NewReader(identity)), c.compression.Brotli)
+	default:
+		return identity, nil
+	}
+}
+
+// originFetch is the buffered result of a single origin round trip, so it
+// can be handed to every singleflight waiter and, if requested, relayed
+// on to a peer.
+type originFetch struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// fetchOrigin proxies req to origin through the existing ModifyResponse
+// pipeline (so the response still gets cached and variants precomputed),
+// but buffers it instead of streaming it straight to a client - the bytes
+// returned here may be relayed to a requesting peer rather than served
+// locally. The fetch is singleflighted on hash so a burst of concurrent
+// misses for the same key reaches origin once.
+func (c *TtlCache) fetchOrigin(hash string, req *http.Request) (int, http.Header, []byte, error) {
+	v, err := c.group.Do(hash, func() (any, error) {
+		clone := req.Clone(req.Context())
+		clone.Host = c.proxyUrl.Hostname()
+
+		rec := httptest.NewRecorder()
+		c.proxy.ServeHTTP(rec, clone)
+
+		res := rec.Result()
+		defer res.Body.Close()
+
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		return &originFetch{status: res.StatusCode, header: res.Header, body: body}, nil
+	})
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	f := v.(*originFetch)
+	return f.status, f.header, f.body, nil
+}
+
+// PeerHandler serves peercache.PeerPath: another node, having picked this
+// one as the owner of a key, asks for it here instead of going to origin
+// itself. A local cache hit is served straight from the store; a miss
+// falls through to fetchOrigin.
+func (c *TtlCache) PeerHandler() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		req := ctx.Request()
+
+		uri := req.URL.Query().Get("uri")
+		if uri == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "missing uri")
+		}
+		reqUrl, err := url.Parse(uri)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid uri")
+		}
+
+		peerReq := req.Clone(req.Context())
+		peerReq.URL.Path = reqUrl.Path
+		peerReq.URL.RawQuery = reqUrl.RawQuery
+
+		key := cacheKey(c.policies, uri, reqUrl.Path, peerReq.Method, peerReq.Header)
+
+		if d, err := c.store.Get(key); err == nil {
+			if identity, ok := d.Variants[cache.IdentityEncoding]; ok {
+				return ctx.Blob(http.StatusOK, d.ContentType, identity)
+			}
+		}
+
+		hash, err := cache.HashKey(key)
+		if err != nil {
+			return err
+		}
+
+		status, header, body, err := c.fetchOrigin(hash, peerReq)
+		if err != nil {
+			return err
+		}
+		return ctx.Blob(status, header.Get("Content-Type"), body)
+	}
+}
+
 func (c *TtlCache) middlewareHandler(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		req := ctx.Request()
 
-		d, err := c.store.Get(req.URL.RequestURI())
+		// Requests a policy excludes from the cache entirely (or that
+		// have no matching policy and aren't GET/HEAD) go straight to
+		// origin without a lookup.
+		if _, ok := cacheable(c.policies, req.URL.Path, req.Method); !ok {
+			req.Host = c.proxyUrl.Hostname()
+			c.proxy.ServeHTTP(ctx.Response(), req)
+			return nil
+		}
+
+		key := cacheKey(c.policies, req.URL.RequestURI(), req.URL.Path, req.Method, req.Header)
+
+		d, err := c.store.Get(key)
 		// cache miss - proxy the request
 		if errors.Is(err, cache.ErrNoSuchKey) || errors.Is(err, cache.ErrExpired) {
 			c.logger.Debug("not stored", err)
+
+			// With no peer pool, or when this node is itself the hash
+			// ring owner of the key, there's nothing to relay: stream
+			// straight from origin exactly like a single-node
+			// deployment, rather than paying fetchOrigin's buffering
+			// cost for a request nobody else needs a copy of.
+			if c.pool == nil {
+				req.Host = c.proxyUrl.Hostname()
+				c.proxy.ServeHTTP(ctx.Response(), req)
+				return nil
+			}
+
+			hash, herr := cache.HashKey(key)
+			if herr != nil {
+				return herr
+			}
+
+			owner, isSelf := c.pool.PickOwner(hash)
+			if isSelf {
+				req.Host = c.proxyUrl.Hostname()
+				c.proxy.ServeHTTP(ctx.Response(), req)
+				return nil
+			}
+
+			// Not the owner: relay through it, falling back to a direct
+			// origin fetch if the owner can't be reached.
+			if body, header, perr := c.pool.FetchFromPeer(owner, hash, req.URL.RequestURI()); perr == nil {
+				if enc := header.Get("Content-Encoding"); enc != "" {
+					ctx.Response().Header().Set("Content-Encoding", enc)
+				}
+				return ctx.Blob(http.StatusOK, header.Get("Content-Type"), body)
+			} else {
+				c.logger.Errorf("peer %s unreachable, fetching from origin instead: %s", owner, perr)
+			}
+
 			req.Host = c.proxyUrl.Hostname()
-			res := ctx.Response()
-			c.proxy.ServeHTTP(res, req)
+			c.proxy.ServeHTTP(ctx.Response(), req)
 			return nil
 		} else if err != nil {
 			return err
@@ -104,10 +342,38 @@ func (c *TtlCache) middlewareHandler(next echo.HandlerFunc) echo.HandlerFunc {
 		h.Set("X-Ouchi-Cache", "cached")
 		c.setConfiguredHeaders(h)
 
+		acceptEncoding := req.Header.Get("Accept-Encoding")
+		encoding, content, ok := pickVariant(d, acceptEncoding)
+		if !ok {
+			return errors.New("cached entry missing identity variant")
+		}
+
+		// Preferred encoding not precomputed yet: compress on demand and
+		// cache the result so the next request for it is a hit. Bodies
+		// smaller than minCompressBytes are served as identity.
+		if encoding == cache.IdentityEncoding && int64(len(content)) >= c.minCompressBytes {
+			if want := acceptedEncodings(acceptEncoding); len(want) > 0 {
+				if compressed, cerr := c.compressOnDemand(want[0], content); cerr == nil {
+					encoding, content = want[0], compressed
+					go func(enc string, body []byte) {
+						if err := c.store.SetVariant(key, enc, body); err != nil && !errors.Is(err, cache.ErrNoSuchKey) {
+							c.logger.Error("failed to cache variant", err)
+						}
+					}(encoding, content)
+				} else {
+					c.logger.Error("failed to compress on demand", cerr)
+				}
+			}
+		}
+
+		if encoding != cache.IdentityEncoding {
+			h.Set("Content-Encoding", encoding)
+		}
+
 		return ctx.Blob(
 			http.StatusOK,
 			d.ContentType,
-			d.Data,
+			content,
 		)
 	}
 }
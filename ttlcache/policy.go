@@ -0,0 +1,103 @@
+package ttlcache
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Policy is one rule of ConfigFile.Policies, matched in order against a
+// request's path and method to decide whether TtlCache should look it
+// up in, and store it to, the cache.
+type Policy struct {
+	// PathPattern matches the request path. A trailing "/*" matches the
+	// whole subtree under the prefix it precedes, e.g. "/api/*" matches
+	// "/api/foo" and "/api/foo/bar". A leading "*" matches a suffix,
+	// e.g. "*.m3u8" matches any path ending in ".m3u8". Anything else is
+	// matched exactly.
+	PathPattern string `json:"path_pattern"`
+	// Methods this policy applies to. Empty means GET and HEAD.
+	Methods []string `json:"methods"`
+	// TtlSec overrides the store's default TTL for matching responses, in
+	// seconds - scaled to a time.Duration by cacheable, the same
+	// convention ConfigFile.TtlSec follows. <=0 keeps the default.
+	TtlSec time.Duration `json:"ttl_sec"`
+	// NoCache excludes matching requests from the cache entirely.
+	NoCache bool `json:"no_cache"`
+	// VaryHeaders are folded into the cache key alongside the path, so
+	// that Vary-sensitive variants of the same path can coexist.
+	VaryHeaders []string `json:"vary_headers"`
+}
+
+func (p Policy) matchesMethod(method string) bool {
+	if len(p.Methods) == 0 {
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	return slices.ContainsFunc(p.Methods, func(m string) bool {
+		return strings.EqualFold(m, method)
+	})
+}
+
+func (p Policy) matches(urlPath, method string) bool {
+	if !p.matchesMethod(method) {
+		return false
+	}
+
+	return matchPath(p.PathPattern, urlPath)
+}
+
+// matchPath implements the subset of globbing documented on
+// Policy.PathPattern. path.Match was tried first, but its "*" doesn't
+// cross "/", so neither a subtree exclude like "/api/*" nor a leading
+// suffix match like "*.m3u8" (no request path lacks the leading "/" that
+// "*" can't consume) behave as the obvious pattern suggests.
+func matchPath(pattern, urlPath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+		return urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(urlPath, suffix)
+	}
+	return pattern == urlPath
+}
+
+// matchPolicy returns the first policy matching path and method, in
+// configured order.
+func matchPolicy(policies []Policy, urlPath, method string) (Policy, bool) {
+	for _, p := range policies {
+		if p.matches(urlPath, method) {
+			return p, true
+		}
+	}
+	return Policy{}, false
+}
+
+// cacheable reports whether a request should participate in the cache
+// at all, and the TTL override to store it with (<=0 meaning "use the
+// store's default"). With no matching policy, only GET/HEAD are cached;
+// every other method bypasses the cache.
+func cacheable(policies []Policy, urlPath, method string) (ttl time.Duration, ok bool) {
+	policy, found := matchPolicy(policies, urlPath, method)
+	if !found {
+		return 0, method == http.MethodGet || method == http.MethodHead
+	}
+	if policy.NoCache {
+		return 0, false
+	}
+	return time.Second * policy.TtlSec, true
+}
+
+// cacheKey folds a matching policy's VaryHeaders into key, so that
+// Vary-sensitive variants of the same path coexist in the store.
+func cacheKey(policies []Policy, key, urlPath, method string, header http.Header) string {
+	policy, found := matchPolicy(policies, urlPath, method)
+	if !found || len(policy.VaryHeaders) == 0 {
+		return key
+	}
+
+	for _, h := range policy.VaryHeaders {
+		key += "|" + h + "=" + header.Get(h)
+	}
+	return key
+}
@@ -0,0 +1,42 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern string
+		urlPath string
+		want    bool
+	}{
+		{"/api/*", "/api/foo", true},
+		{"/api/*", "/api/foo/bar", true},
+		{"/api/*", "/apix/foo", false},
+		{"/api/*", "/other", false},
+		{"*.m3u8", "/video.m3u8", true},
+		{"*.m3u8", "/a/b/video.m3u8", true},
+		{"*.m3u8", "/video.mp4", false},
+		{"/exact", "/exact", true},
+		{"/exact", "/exact/more", false},
+	}
+
+	for _, c := range cases {
+		if got := matchPath(c.pattern, c.urlPath); got != c.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", c.pattern, c.urlPath, got, c.want)
+		}
+	}
+}
+
+func TestCacheableScalesTtlSecToSeconds(t *testing.T) {
+	policies := []Policy{{PathPattern: "/api/*", TtlSec: 300}}
+
+	ttl, ok := cacheable(policies, "/api/foo", "GET")
+	if !ok {
+		t.Fatal("expected /api/foo to be cacheable")
+	}
+	if want := 300 * time.Second; ttl != want {
+		t.Errorf("ttl = %v, want %v", ttl, want)
+	}
+}
@@ -2,6 +2,7 @@ package ttlcache
 
 import (
 	"errors"
+	"ouchi/log"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -16,7 +17,15 @@ type TtlCacheConfig struct {
 	Ttl      time.Duration
 	Tick     time.Duration
 	Headers  map[string]string
-	Logger   Logger
+	Logger   log.Logger
+
+	// StreamThreshold is how much of an origin response
+	// ChunkedCacheWriter stages in memory before spilling to a temp
+	// file. <=0 means never spill.
+	StreamThreshold int64
+	// MaxCacheableBytes bypasses caching, without affecting proxying,
+	// for responses larger than this. <=0 means unbounded.
+	MaxCacheableBytes int64
 }
 
 type ChacheData struct {